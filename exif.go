@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"os"
+
+	"github.com/rwcarlsen/goexif/exif"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// PhotoMetadata는 워터마크를 적용하기 전에 원본 EXIF에서 뽑아낸, 사람이 보기 좋은 형태의
+// 메타데이터입니다. 사생활 보호를 위해 출력 이미지에는 다시 기록하지 않고, 설정된 경우에만
+// 별도의 사이드카 JSON으로 남겨둡니다.
+type PhotoMetadata struct {
+	Make             string  `json:"make,omitempty"`
+	Model            string  `json:"model,omitempty"`
+	DateTimeOriginal string  `json:"date_time_original,omitempty"`
+	GPSLatitude      float64 `json:"gps_latitude,omitempty"`
+	GPSLongitude     float64 `json:"gps_longitude,omitempty"`
+	HasGPS           bool    `json:"-"`
+}
+
+func (m PhotoMetadata) isEmpty() bool {
+	return m.Make == "" && m.Model == "" && m.DateTimeOriginal == "" && !m.HasGPS
+}
+
+// readExif는 jpeg/tiff 페이로드에서 방향(Orientation) 태그와 카메라/시간/위치 메타데이터를
+// 읽어옵니다. EXIF가 없는 포맷(png, gif, bmp, webp)이거나 EXIF 세그먼트 자체가 없으면
+// orientation은 1(정방향)로, meta는 빈 값으로 반환하며 에러로 취급하지 않습니다.
+func readExif(r io.Reader, ext string) (orientation int, meta PhotoMetadata, err error) {
+	orientation = 1
+	if ext != "jpeg" && ext != "jpg" && ext != "tif" && ext != "tiff" {
+		return orientation, meta, nil
+	}
+
+	x, decodeErr := exif.Decode(r)
+	if decodeErr != nil {
+		// 휴대폰이 아닌 곳에서 온 jpeg/tiff는 EXIF가 없는 경우가 흔하므로 에러로 취급하지 않습니다.
+		return orientation, meta, nil
+	}
+
+	if tag, tagErr := x.Get(exif.Orientation); tagErr == nil {
+		if v, err := tag.Int(0); err == nil {
+			orientation = v
+		}
+	}
+	if tag, tagErr := x.Get(exif.Make); tagErr == nil {
+		meta.Make, _ = tag.StringVal()
+	}
+	if tag, tagErr := x.Get(exif.Model); tagErr == nil {
+		meta.Model, _ = tag.StringVal()
+	}
+	if tag, tagErr := x.Get(exif.DateTimeOriginal); tagErr == nil {
+		meta.DateTimeOriginal, _ = tag.StringVal()
+	}
+	if lat, long, latErr := x.LatLong(); latErr == nil {
+		meta.GPSLatitude, meta.GPSLongitude, meta.HasGPS = lat, long, true
+	}
+	return orientation, meta, nil
+}
+
+// newCanvasLike는 img와 같은 종류(팔레트 유지 여부)의 w x h 빈 캔버스를 만듭니다.
+func newCanvasLike(img image.Image, w, h int) draw.Image {
+	if p, ok := img.(*image.Paletted); ok {
+		return image.NewPaletted(image.Rect(0, 0, w, h), p.Palette)
+	}
+	return image.NewRGBA(image.Rect(0, 0, w, h))
+}
+
+// applyOrientation은 EXIF Orientation 태그(1~8)가 가리키는 방향으로 이미지를 회전/반전해,
+// 실제로 보이는 모습이 올바르도록 정규화합니다. orientation이 1이거나 알 수 없는 값이면
+// 원본을 그대로 반환합니다.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return flipHorizontal(img)
+	case 3:
+		return rotate180(img)
+	case 4:
+		return flipVertical(img)
+	case 5:
+		return rotate270CW(flipHorizontal(img))
+	case 6:
+		return rotate90CW(img)
+	case 7:
+		return rotate90CW(flipHorizontal(img))
+	case 8:
+		return rotate270CW(img)
+	default:
+		return img
+	}
+}
+
+func flipHorizontal(img image.Image) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := newCanvasLike(img, w, h)
+	for sy := 0; sy < h; sy++ {
+		for sx := 0; sx < w; sx++ {
+			dst.Set(w-1-sx, sy, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+func flipVertical(img image.Image) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := newCanvasLike(img, w, h)
+	for sy := 0; sy < h; sy++ {
+		for sx := 0; sx < w; sx++ {
+			dst.Set(sx, h-1-sy, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+func rotate180(img image.Image) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := newCanvasLike(img, w, h)
+	for sy := 0; sy < h; sy++ {
+		for sx := 0; sx < w; sx++ {
+			dst.Set(w-1-sx, h-1-sy, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// rotate90CW는 이미지를 시계 방향으로 90도 회전시킵니다(가로/세로가 뒤바뀝니다).
+func rotate90CW(img image.Image) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := newCanvasLike(img, h, w)
+	for sy := 0; sy < h; sy++ {
+		for sx := 0; sx < w; sx++ {
+			dst.Set(h-1-sy, sx, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// rotate270CW는 이미지를 시계 방향으로 270도(반시계 90도) 회전시킵니다.
+func rotate270CW(img image.Image) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := newCanvasLike(img, h, w)
+	for sy := 0; sy < h; sy++ {
+		for sx := 0; sx < w; sx++ {
+			dst.Set(sy, w-1-sx, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// exifSidecarPrefix는 EXIF_METADATA_PREFIX 환경 변수로 설정하며, 비어있으면 사이드카
+// 메타데이터를 쓰지 않습니다(기본은 비활성화 — 카메라/위치 정보는 민감한 정보이므로
+// 명시적으로 켜야만 기록됩니다).
+func exifSidecarPrefix() string {
+	return os.Getenv("EXIF_METADATA_PREFIX")
+}
+
+// writeMetadataSidecar는 읽어들인 EXIF 메타데이터를 exifSidecarPrefix() 아래에 JSON
+// 사이드카 객체로 남깁니다. prefix가 비어있거나 meta가 비어있으면 아무것도 하지 않습니다.
+func writeMetadataSidecar(uploader *s3manager.Uploader, bucket, key string, meta PhotoMetadata) error {
+	prefix := exifSidecarPrefix()
+	if prefix == "" || meta.isEmpty() {
+		return nil
+	}
+
+	body, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("unable to marshal exif metadata for %q: %w", key, err)
+	}
+
+	destKey := prefix + basenameWithExt(key, "json")
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(destKey),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to write exif sidecar for %q: %w", key, err)
+	}
+	return nil
+}
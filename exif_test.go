@@ -0,0 +1,77 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// asymmetric builds a 2x3 image where every pixel is distinct, so any
+// rotation/flip bug shows up as a mismatched pixel rather than an
+// accidentally-still-correct symmetric case.
+func asymmetric() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 3))
+	img.Set(0, 0, color.RGBA{1, 0, 0, 255})
+	img.Set(1, 0, color.RGBA{2, 0, 0, 255})
+	img.Set(0, 1, color.RGBA{3, 0, 0, 255})
+	img.Set(1, 1, color.RGBA{4, 0, 0, 255})
+	img.Set(0, 2, color.RGBA{5, 0, 0, 255})
+	img.Set(1, 2, color.RGBA{6, 0, 0, 255})
+	return img
+}
+
+func pixelAt(img image.Image, x, y int) uint32 {
+	r, _, _, _ := img.At(x, y).RGBA()
+	return r
+}
+
+func TestApplyOrientation_Identity(t *testing.T) {
+	src := asymmetric()
+	out := applyOrientation(src, 1)
+	if out != src {
+		t.Error("orientation 1 should return the image unchanged")
+	}
+}
+
+func TestApplyOrientation_Transpose(t *testing.T) {
+	// Orientation 5 ("transpose"): flip horizontal, then rotate 270CW.
+	// Equivalent to a straight transpose (x,y) -> (y,x) about the main diagonal.
+	src := asymmetric()
+	out := applyOrientation(src, 5)
+
+	b := out.Bounds()
+	if b.Dx() != 3 || b.Dy() != 2 {
+		t.Fatalf("orientation 5 got %dx%d, want 3x2", b.Dx(), b.Dy())
+	}
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 2; x++ {
+			want := pixelAt(src, x, y)
+			got := pixelAt(out, y, x)
+			if got != want {
+				t.Errorf("orientation 5: out(%d,%d)=%d, want src(%d,%d)=%d", y, x, got, x, y, want)
+			}
+		}
+	}
+}
+
+func TestApplyOrientation_Transverse(t *testing.T) {
+	// Orientation 7 ("transverse"): flip horizontal, then rotate 90CW.
+	// Equivalent to a transpose about the anti-diagonal: (x,y) -> (h-1-y, w-1-x).
+	src := asymmetric()
+	out := applyOrientation(src, 7)
+
+	b := out.Bounds()
+	if b.Dx() != 3 || b.Dy() != 2 {
+		t.Fatalf("orientation 7 got %dx%d, want 3x2", b.Dx(), b.Dy())
+	}
+	w, h := 2, 3
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			want := pixelAt(src, x, y)
+			got := pixelAt(out, h-1-y, w-1-x)
+			if got != want {
+				t.Errorf("orientation 7: out(%d,%d)=%d, want src(%d,%d)=%d", h-1-y, w-1-x, got, x, y, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// defaultOutputPrefix는 OUTPUT_PREFIX 환경 변수가 없을 때 워터마크 처리된 이미지를
+// 저장하는 접두사입니다. 입력 버킷과 출력 버킷이 같을 때, 이 접두사 아래로 쓰는 것 자체가
+// 새 S3 이벤트를 발생시켜 무한 루프를 유발할 수 있으므로 반드시 건너뛰어야 합니다.
+const defaultOutputPrefix = "labeled-images/"
+
+func outputPrefix() string {
+	if v := os.Getenv("OUTPUT_PREFIX"); v != "" {
+		return v
+	}
+	return defaultOutputPrefix
+}
+
+// managedOutputPrefixes는 파이프라인이 직접 쓰는 모든 접두사(워터마크 결과물
+// outputPrefix()뿐 아니라 resize.go가 만드는 thumb-128/, medium-512/ 같은 리사이즈
+// variant 접두사까지)를 반환합니다. 같은 버킷을 입력/출력으로 함께 쓰는 구성에서는
+// 이 목록에 있는 접두사 아래로 쓰는 것 자체가 새 S3 이벤트를 일으켜 무한 루프(혹은
+// variant가 variant를 재생성하는 증폭 루프)를 유발할 수 있으므로, 하나도 빠짐없이
+// 건너뛰어야 합니다.
+func managedOutputPrefixes() []string {
+	prefixes := []string{outputPrefix()}
+	variants, err := loadVariants()
+	if err != nil {
+		return prefixes
+	}
+	for _, v := range variants {
+		prefixes = append(prefixes, v.Name+"/")
+	}
+	return prefixes
+}
+
+// isAlreadyProcessedOutput은 key가 managedOutputPrefixes() 중 하나의 아래에 있는지
+// 확인합니다. 같은 버킷을 입력/출력으로 함께 쓰는 구성에서, 우리가 직접 쓴 결과물이
+// (워터마크 원본이든 리사이즈 variant든) 다시 이벤트를 발생시켜도 재처리되지 않도록
+// 막는 가드입니다.
+func isAlreadyProcessedOutput(key string) bool {
+	for _, prefix := range managedOutputPrefixes() {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func buildDestinationKey(key, outExt string) string {
+	return outputPrefix() + basenameWithExt(key, outExt)
+}
+
+// ProcessedEventStore는 DynamoDB에 bucket+key+versionId+eventTime으로 처리 완료된
+// S3 이벤트를 기록해, 재전송된(redelivered) 이벤트를 정확히 한 번만 처리하도록 합니다.
+type ProcessedEventStore struct {
+	client *dynamodb.DynamoDB
+	table  string
+}
+
+// NewProcessedEventStore는 PROCESSED_EVENTS_TABLE 환경 변수가 설정된 경우에만
+// 유효한 스토어를 반환합니다. 설정되지 않으면 nil을 반환하며, 호출자는 nil 체크로
+// 이 기능을 선택적으로(opt-in) 사용할 수 있습니다.
+func NewProcessedEventStore(sess *dynamodb.DynamoDB) *ProcessedEventStore {
+	table := os.Getenv("PROCESSED_EVENTS_TABLE")
+	if table == "" {
+		return nil
+	}
+	return &ProcessedEventStore{client: sess, table: table}
+}
+
+func eventID(bucket, key, versionID, eventTime string) string {
+	return fmt.Sprintf("%s#%s#%s#%s", bucket, key, versionID, eventTime)
+}
+
+// AlreadyProcessed는 이 이벤트가 이전에 끝까지 처리되었는지 확인합니다.
+func (s *ProcessedEventStore) AlreadyProcessed(bucket, key, versionID, eventTime string) (bool, error) {
+	if s == nil {
+		return false, nil
+	}
+	out, err := s.client.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"EventID": {S: aws.String(eventID(bucket, key, versionID, eventTime))},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to check processed-event table: %w", err)
+	}
+	return out.Item != nil, nil
+}
+
+// MarkProcessed는 이벤트를 처리 완료로 기록합니다.
+func (s *ProcessedEventStore) MarkProcessed(bucket, key, versionID, eventTime string) error {
+	if s == nil {
+		return nil
+	}
+	item, err := dynamodbattribute.MarshalMap(struct {
+		EventID     string `dynamodbav:"EventID"`
+		Bucket      string `dynamodbav:"Bucket"`
+		Key         string `dynamodbav:"Key"`
+		ProcessedAt string `dynamodbav:"EventTime"`
+	}{
+		EventID:     eventID(bucket, key, versionID, eventTime),
+		Bucket:      bucket,
+		Key:         key,
+		ProcessedAt: eventTime,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to marshal processed-event record: %w", err)
+	}
+	_, err = s.client.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to write processed-event record: %w", err)
+	}
+	return nil
+}
+
+// sourceETagMatches는 대상 키가 이미 존재하고 그 x-amz-meta-source-etag 메타데이터가
+// 현재 원본 객체의 ETag와 같은지 HeadObject로 확인합니다. 같다면 이미 이 원본으로부터
+// 생성된 결과물이므로 재처리를 건너뛸 수 있습니다.
+func sourceETagMatches(client *s3.S3, bucket, destKey, sourceETag string) (bool, error) {
+	out, err := client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(destKey),
+	})
+	if err != nil {
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && (awsErr.Code() == s3.ErrCodeNoSuchKey || awsErr.Code() == "NotFound") {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to head %q: %w", destKey, err)
+	}
+
+	existing := out.Metadata["Source-Etag"]
+	if existing == nil {
+		return false, nil
+	}
+	return strings.Trim(*existing, `"`) == strings.Trim(sourceETag, `"`), nil
+}
+
+// publishToDLQ는 처리에 실패한 S3 레코드를 SQS 데드레터 큐로 전달해, 전체 invocation을
+// 실패시켜 모든 레코드를 재시도하는 대신 그 레코드만 별도로 추적/재처리할 수 있게 합니다.
+// DLQ_QUEUE_URL이 설정되지 않으면 원래 에러를 그대로 반환합니다.
+func publishToDLQ(client *sqs.SQS, bucket, key string, causeErr error) error {
+	queueURL := os.Getenv("DLQ_QUEUE_URL")
+	if queueURL == "" {
+		return causeErr
+	}
+
+	body, err := json.Marshal(struct {
+		Bucket string `json:"bucket"`
+		Key    string `json:"key"`
+		Error  string `json:"error"`
+	}{Bucket: bucket, Key: key, Error: causeErr.Error()})
+	if err != nil {
+		return fmt.Errorf("unable to marshal dlq message for %q: %w (original error: %v)", key, err, causeErr)
+	}
+
+	_, err = client.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(queueURL),
+		MessageBody: aws.String(string(body)),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to publish %q to dlq: %w (original error: %v)", key, err, causeErr)
+	}
+	return nil
+}
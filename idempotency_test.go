@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestIsAlreadyProcessedOutput(t *testing.T) {
+	os.Unsetenv("OUTPUT_PREFIX")
+	os.Unsetenv("RESIZE_VARIANTS")
+
+	cases := []struct {
+		key  string
+		want bool
+	}{
+		{"labeled-images/photo.jpg", true},
+		{"thumb-128/photo.jpg", true},
+		{"medium-512/photo.jpg", true},
+		{"large-1600/photo.jpg", true},
+		{"uploads/photo.jpg", false},
+	}
+	for _, c := range cases {
+		if got := isAlreadyProcessedOutput(c.key); got != c.want {
+			t.Errorf("isAlreadyProcessedOutput(%q) = %v, want %v", c.key, got, c.want)
+		}
+	}
+}
+
+func TestIsAlreadyProcessedOutput_CustomVariants(t *testing.T) {
+	os.Setenv("RESIZE_VARIANTS", `[{"name":"square-64","width":64,"height":64,"mode":"fill"}]`)
+	defer os.Unsetenv("RESIZE_VARIANTS")
+
+	if !isAlreadyProcessedOutput("square-64/photo.jpg") {
+		t.Error("expected key under a configured custom variant prefix to be treated as already processed")
+	}
+	if isAlreadyProcessedOutput("thumb-128/photo.jpg") {
+		t.Error("default variant prefix should not be guarded once RESIZE_VARIANTS overrides the list")
+	}
+}
+
+func newTestS3Client(t *testing.T, handler http.HandlerFunc) *s3.S3 {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(srv.URL),
+		DisableSSL:       aws.Bool(true),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	return s3.New(sess)
+}
+
+func TestSourceETagMatches(t *testing.T) {
+	client := newTestS3Client(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-amz-meta-source-etag", `"abc123"`)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	match, err := sourceETagMatches(client, "bucket", "labeled-images/photo.jpg", `"abc123"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !match {
+		t.Error("expected matching source etag to report true")
+	}
+}
+
+func TestSourceETagMatches_Mismatch(t *testing.T) {
+	client := newTestS3Client(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-amz-meta-source-etag", `"other-etag"`)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	match, err := sourceETagMatches(client, "bucket", "labeled-images/photo.jpg", `"abc123"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if match {
+		t.Error("expected mismatched source etag to report false")
+	}
+}
+
+func TestSourceETagMatches_NotFound(t *testing.T) {
+	client := newTestS3Client(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	match, err := sourceETagMatches(client, "bucket", "labeled-images/photo.jpg", `"abc123"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if match {
+		t.Error("expected missing destination object to report false, not an error")
+	}
+}
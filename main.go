@@ -4,19 +4,22 @@ import (
 	"bytes"
 	"fmt"
 	"image"
-	"image/color"
 	"image/draw"
+	"image/gif"
 	"image/jpeg"
 	_ "image/jpeg"
 	"image/png"
 	_ "image/png"
-	"path"
+	"io"
+	"os"
+	"strconv"
 	"strings"
-	"sync"
+	"time"
 
-	"golang.org/x/image/font"
-	"golang.org/x/image/font/basicfont"
-	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+	"golang.org/x/sync/errgroup"
 
 	"context"
 	"log"
@@ -25,35 +28,37 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
-func addLabel(img image.Image, x, y int, label string) image.Image {
-	// 원래 이미지와 같은 사이즈로 새로운 이미지 생성
-	rgba := image.NewRGBA(img.Bounds())
-	// img의 모든 픽셀을 rgba에다 그대로 덮어 씌움
-	draw.Draw(rgba, rgba.Bounds(), img, image.Point{0, 0}, draw.Src)
-	// 색상을 생성합니다. RGBA에서 255, 0, 0, 255는 빨간색을 나타냅니다.
-	col := color.RGBA{255, 0, 0, 255}
-	// 텍스트를 그릴 시작점을 설정합니다. x와 y는 픽셀 단위의 좌표입니다.
-	point := fixed.Point26_6{fixed.Int26_6(x * 64), fixed.Int26_6(y * 64)}
-
-	d := &font.Drawer{
-		Dst:  rgba,                  // Dst는 목적지 이미지를 지정합니다. 여기서 rgba는 수정될 이미지입니다.
-		Src:  image.NewUniform(col), // Src는 텍스트의 색상을 지정합니다. 여기서는 빨간색을 사용합니다.
-		Face: basicfont.Face7x13,    // Face는 사용할 폰트를 지정합니다. 여기서는 기본 폰트 Face7x13을 사용합니다.
-		Dot:  point,                 // Dot은 텍스트를 시작할 위치를 지정합니다.
-	}
-	// 지정된 설정을 사용하여 이미지에 label을 그립니다.
-	d.DrawString(label)
+// defaultMaxConcurrency는 MAX_CONCURRENCY 환경 변수가 설정되지 않았을 때 한 번의
+// Lambda 호출 안에서 동시에 처리할 S3 레코드 개수입니다.
+const defaultMaxConcurrency = 5
 
-	return rgba
+// defaultMaxDecodedPixels는 MAX_DECODED_PIXELS 환경 변수가 설정되지 않았을 때
+// 디코딩을 허용하는 최대 픽셀 수(가로 x 세로)입니다. 압축 해제 폭탄으로부터 Lambda의
+// 메모리를 보호하기 위한 상한선입니다.
+const defaultMaxDecodedPixels = 64_000_000 // 예: 8000x8000
 
+// toDrawable은 img를 직접 그릴 수 있으면(이미 draw.Image를 구현하면, 예: 디코딩된
+// *image.Paletted나 *image.RGBA) 그대로 반환하고, 그렇지 않으면(예: jpeg의
+// *image.YCbCr) 비로소 새 RGBA 버퍼를 할당해 복사합니다. 불필요한 두 번째 전체 프레임
+// 복사를 피하기 위한 것으로, 가능한 경우 디코딩된 이미지 위에 바로 워터마크를 그립니다.
+func toDrawable(img image.Image) draw.Image {
+	if d, ok := img.(draw.Image); ok {
+		return d
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
 }
 
 func isImageFile(key string) (bool, string) {
 	// 지원되는 이미지 파일 확장자 리스트
-	supportedExtensions := []string{".jpg", ".jpeg", ".png"}
+	supportedExtensions := []string{".jpg", ".jpeg", ".png", ".gif", ".tif", ".tiff", ".bmp", ".webp"}
 	for _, ext := range supportedExtensions {
 		if strings.HasSuffix(strings.ToLower(key), ext) {
 			return true, ext[1:]
@@ -62,16 +67,59 @@ func isImageFile(key string) (bool, string) {
 	return false, ""
 }
 
-func handleImage(wg *sync.WaitGroup, client *s3.S3, record *events.S3EventRecord) {
-	defer wg.Done()
+// resolveOutputExt는 인코더가 없는 포맷(tiff, webp)을 무엇으로 대체해 저장할지
+// 결정합니다. 다른 모든 포맷은 입력과 동일한 확장자로 저장됩니다.
+func resolveOutputExt(ext string) string {
+	switch ext {
+	case "tif", "tiff", "webp":
+		return "png"
+	default:
+		return ext
+	}
+}
+
+func handleImage(client *s3.S3, uploader *s3manager.Uploader, cfg *Config, store *ProcessedEventStore, record *events.S3EventRecord) error {
 	s3Entity := record.S3
 	bucket := s3Entity.Bucket.Name
 	key := s3Entity.Object.Key
 	log.Println("start to process ", key)
-	isImage, ext := isImageFile(key)
 
+	// 같은 버킷을 입력/출력으로 함께 쓰면 우리가 쓴 결과물이 새 이벤트를 일으켜
+	// 무한 루프를 유발할 수 있으므로, 출력 접두사 아래의 키는 바로 건너뜁니다.
+	if isAlreadyProcessedOutput(key) {
+		log.Println("skipping output-prefixed key", key)
+		return nil
+	}
+
+	isImage, ext := isImageFile(key)
 	if !isImage {
-		return
+		return nil
+	}
+
+	versionID := s3Entity.Object.VersionID
+	eventTime := record.EventTime.Format(time.RFC3339Nano)
+	if store != nil {
+		done, err := store.AlreadyProcessed(bucket, key, versionID, eventTime)
+		if err != nil {
+			return err
+		}
+		if done {
+			log.Println("skipping already-processed event for", key)
+			return nil
+		}
+	}
+
+	outExt := resolveOutputExt(ext)
+	destKey := buildDestinationKey(key, outExt)
+	sourceETag := s3Entity.Object.ETag
+
+	// Lambda는 같은 S3 이벤트를 다시 전달할 수 있습니다. 대상 키가 이미 같은 원본
+	// ETag로부터 만들어졌다면 재다운로드/재워터마크/재업로드를 건너뜁니다.
+	if match, err := sourceETagMatches(client, bucket, destKey, sourceETag); err != nil {
+		return err
+	} else if match {
+		log.Println("skipping, destination already produced from this source etag", key)
+		return nil
 	}
 
 	// S3에서 이미지 가져오기
@@ -80,70 +128,260 @@ func handleImage(wg *sync.WaitGroup, client *s3.S3, record *events.S3EventRecord
 		Key:    aws.String(key),
 	})
 	if err != nil {
-		// 에러 처리
-		log.Fatalf("Unable to download item %q, %v", key, err)
+		return fmt.Errorf("unable to download item %q: %w", key, err)
 	}
 	defer resp.Body.Close()
 
-	img, _, err := image.Decode(resp.Body)
+	// 객체의 사용자 메타데이터로 이 객체 한정 워터마크 오버라이드를 적용합니다.
+	objectCfg := ApplyOverrides(cfg, resp.Metadata)
+
+	data, err := loadAndGuard(resp.Body, ext, maxDecodedPixels())
 	if err != nil {
-		log.Fatalf("Unable to decode image %q, %v", key, err)
+		return fmt.Errorf("rejecting %q: %w", key, err)
 	}
 
-	bounds := img.Bounds()
-	var labeledImage image.Image
-	if bounds.Max.X < 20 && bounds.Max.Y < 20 {
-		labeledImage = addLabel(img, 0, 0, "This is watermark")
-		log.Printf("add label to the image at point %v, %v\n", bounds.Max.X, bounds.Max.X)
-	} else {
-		labeledImage = addLabel(img, 20, 20, "This is watermark")
-		log.Printf("add label to the image at point %v, %v\n", bounds.Max.X, bounds.Max.X)
+	// EXIF Orientation을 디코딩 전에 먼저 읽어둡니다. 출력 이미지는 픽셀만 다시
+	// 인코딩하므로 이 시점 이후로는 원본의 EXIF/GPS가 결과물에 남지 않습니다.
+	orientation, photoMeta, err := readExif(bytes.NewReader(data), ext)
+	if err != nil {
+		return fmt.Errorf("unable to read exif for %q: %w", key, err)
+	}
+	if err := writeMetadataSidecar(uploader, bucket, key, photoMeta); err != nil {
+		return err
 	}
 
-	buf := new(bytes.Buffer)
+	// GIF는 애니메이션일 수 있으므로, 단일 프레임 디코딩 경로와 별도로 처리합니다.
+	// (애니메이션 GIF는 썸네일 파생본 생성 대상에서는 제외합니다.)
+	if ext == "gif" {
+		pr, pw := io.Pipe()
+		go func() {
+			var encErr error
+			defer func() { pw.CloseWithError(encErr) }()
 
-	switch ext {
-	case "png":
-		err := png.Encode(buf, labeledImage)
-		if err != nil {
-			log.Fatal(err)
+			g, err := gif.DecodeAll(bytes.NewReader(data))
+			if err != nil {
+				encErr = fmt.Errorf("unable to decode gif %q: %w", key, err)
+				return
+			}
+			// 프레임의 Rect는 디스포절 최적화로 논리 화면 전체보다 작거나 원점이
+			// 아닐 수 있으므로(흔히 발생), 앵커는 프레임 자신이 아니라 논리 화면
+			// 크기(canvas) 기준으로 한 번만 계산해 모든 프레임에 동일하게 적용합니다.
+			canvas := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+			for i, frame := range g.Image {
+				dst := toDrawable(frame)
+				if err := ApplyWithCanvas(dst, canvas, objectCfg, client); err != nil {
+					encErr = fmt.Errorf("unable to watermark gif frame of %q: %w", key, err)
+					return
+				}
+				g.Image[i] = dst.(*image.Paletted)
+			}
+			encErr = gif.EncodeAll(pw, g)
+		}()
+
+		log.Println("Streaming watermarked gif to s3")
+		if _, err := uploader.Upload(&s3manager.UploadInput{
+			Bucket:      aws.String(bucket),
+			Key:         aws.String(destKey),
+			Body:        pr,
+			ContentType: aws.String(fmt.Sprintf("image/%v", outExt)),
+			Metadata:    map[string]*string{"Source-Etag": aws.String(sourceETag)},
+		}); err != nil {
+			return fmt.Errorf("failed to save %q to s3: %w", key, err)
 		}
-	case "jpeg", "jpg":
-		err := jpeg.Encode(buf, labeledImage, nil)
+	} else {
+		img, err := decodeByExt(bytes.NewReader(data), ext)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("unable to decode image %q: %w", key, err)
+		}
+		img = applyOrientation(img, orientation)
+
+		labeledImage := toDrawable(img)
+		if err := Apply(labeledImage, objectCfg, client); err != nil {
+			return fmt.Errorf("unable to watermark %q: %w", key, err)
+		}
+		log.Println("applied watermark config to", key)
+
+		pr, pw := io.Pipe()
+		go func() {
+			var encErr error
+			defer func() { pw.CloseWithError(encErr) }()
+			switch ext {
+			case "png":
+				encErr = png.Encode(pw, labeledImage)
+			case "jpeg", "jpg":
+				encErr = jpeg.Encode(pw, labeledImage, nil)
+			case "bmp":
+				encErr = bmp.Encode(pw, labeledImage)
+			case "tif", "tiff", "webp":
+				// golang.org/x/image에는 tiff와 webp 인코더가 없으므로, 디코딩한 뒤
+				// 워터마크를 입힌 결과는 PNG로 저장합니다.
+				encErr = png.Encode(pw, labeledImage)
+			}
+		}()
+
+		log.Println("Streaming watermarked image to s3")
+		if _, err := uploader.Upload(&s3manager.UploadInput{
+			Bucket:      aws.String(bucket),
+			Key:         aws.String(destKey),
+			Body:        pr,
+			ContentType: aws.String(fmt.Sprintf("image/%v", outExt)),
+			Metadata:    map[string]*string{"Source-Etag": aws.String(sourceETag)},
+		}); err != nil {
+			return fmt.Errorf("failed to save %q to s3: %w", key, err)
+		}
+
+		// 워터마크가 적용된 원본 해상도 이미지를 그대로 리샘플링해, 라벨도 각 파생본의
+		// 크기에 비례해 함께 축소/확대되도록 합니다.
+		if variants, err := loadVariants(); err != nil {
+			return err
+		} else if len(variants) > 0 {
+			if err := writeResizeVariants(uploader, labeledImage, bucket, key, ext, variants); err != nil {
+				return fmt.Errorf("unable to write resize variants for %q: %w", key, err)
+			}
 		}
 	}
-	log.Println("Saving image to s3")
-	_, err = client.PutObject(&s3.PutObjectInput{
-		Bucket:      aws.String(s3Entity.Bucket.Name),                             // S3 버킷 이름
-		Key:         aws.String(fmt.Sprintf("labeled-images/%v", path.Base(key))), // 저장될 이미지의 키 (파일 이름)
-		Body:        bytes.NewReader(buf.Bytes()),
-		ContentType: aws.String(fmt.Sprintf("image/%v", ext)), // 또는 "image/png" 등
-	})
 
+	if store != nil {
+		if err := store.MarkProcessed(bucket, key, versionID, eventTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeByExt는 파일 확장자에 따라 알맞은 디코더로 이미지를 디코딩합니다. jpeg/png는
+// image.Decode로 충분하지만, bmp/tiff/webp는 golang.org/x/image의 전용 디코더가 필요합니다.
+func decodeByExt(r io.Reader, ext string) (image.Image, error) {
+	switch ext {
+	case "bmp":
+		return bmp.Decode(r)
+	case "tif", "tiff":
+		return tiff.Decode(r)
+	case "webp":
+		return webp.Decode(r)
+	default:
+		img, _, err := image.Decode(r)
+		return img, err
+	}
+}
+
+// decodeConfigByExt는 decodeByExt와 짝을 이루는 헤더 전용 디코더로, 픽셀 데이터는
+// 건너뛰고 가로/세로 크기만 읽어옵니다.
+func decodeConfigByExt(r io.Reader, ext string) (image.Config, error) {
+	switch ext {
+	case "bmp":
+		return bmp.DecodeConfig(r)
+	case "tif", "tiff":
+		return tiff.DecodeConfig(r)
+	case "webp":
+		return webp.DecodeConfig(r)
+	case "gif":
+		return gif.DecodeConfig(r)
+	default:
+		cfg, _, err := image.DecodeConfig(r)
+		return cfg, err
+	}
+}
+
+// loadAndGuard는 객체 본문 전체를 읽어온 뒤, 본 디코딩에 앞서 가로 x 세로 픽셀 수가
+// maxPixels를 넘지 않는지 확인합니다(압축 해제 폭탄 방지). EXIF 오리엔테이션 읽기와
+// 실제 디코딩 모두 같은 바이트를 여러 번 다시 읽어야 하므로, 스트림 대신 메모리에
+// 담아 bytes.NewReader로 재사용할 수 있게 반환합니다.
+func loadAndGuard(r io.Reader, ext string, maxPixels int64) ([]byte, error) {
+	data, err := io.ReadAll(r)
 	if err != nil {
-		log.Fatalf("failed to save %q to s3: %v", key, err)
+		return nil, fmt.Errorf("unable to read object body: %w", err)
+	}
+
+	cfg, err := decodeConfigByExt(bytes.NewReader(data), ext)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read image header: %w", err)
+	}
+
+	pixels := int64(cfg.Width) * int64(cfg.Height)
+	if pixels > maxPixels {
+		return nil, fmt.Errorf("decoded image would be %d pixels, exceeds limit of %d", pixels, maxPixels)
 	}
+
+	return data, nil
+}
+
+// replaceExt는 출력 포맷이 원본과 달라졌을 때 (예: tiff/webp -> png) 저장되는 파일의
+// 확장자도 함께 바꿔줍니다.
+func replaceExt(name, newExt string) string {
+	dot := strings.LastIndex(name, ".")
+	if dot == -1 {
+		return name + "." + newExt
+	}
+	return name[:dot+1] + newExt
+}
+
+// basenameWithExt는 key의 디렉터리 부분을 떼어내고 확장자를 newExt로 바꾼 파일 이름만
+// 반환합니다. 출력 접두사(labeled-images/, thumb-128/ 등) 뒤에 이어 붙이는 용도입니다.
+func basenameWithExt(key, newExt string) string {
+	renamed := replaceExt(key, newExt)
+	if idx := strings.LastIndex(renamed, "/"); idx != -1 {
+		return renamed[idx+1:]
+	}
+	return renamed
+}
+
+// maxConcurrency는 MAX_CONCURRENCY 환경 변수로 동시 처리 개수를 설정할 수 있게 해주며,
+// 설정하지 않으면 defaultMaxConcurrency를 씁니다.
+func maxConcurrency() int {
+	if v := os.Getenv("MAX_CONCURRENCY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultMaxConcurrency
 }
 
-func HandleRequest(ctx context.Context, s3Event events.S3Event) {
+// maxDecodedPixels는 MAX_DECODED_PIXELS 환경 변수로 디코딩 크기 상한을 설정할 수 있게
+// 해주며, 설정하지 않으면 defaultMaxDecodedPixels를 씁니다.
+func maxDecodedPixels() int64 {
+	if v := os.Getenv("MAX_DECODED_PIXELS"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultMaxDecodedPixels
+}
+
+func HandleRequest(ctx context.Context, s3Event events.S3Event) error {
 	log.Println("Handler start..")
 	// AWS 세션 생성
 	sess := session.Must(session.NewSessionWithOptions(session.Options{
 		SharedConfigState: session.SharedConfigEnable,
 	}))
 	client := s3.New(sess)
+	uploader := s3manager.NewUploader(sess)
+	dlqClient := sqs.New(sess)
+	store := NewProcessedEventStore(dynamodb.New(sess))
+
+	cfg, err := LoadConfig(client)
+	if err != nil {
+		return fmt.Errorf("unable to load watermark config: %w", err)
+	}
 
-	var wg sync.WaitGroup
-	// 이벤트로부터 버킷과 키 추출
+	// 레코드 하나가 실패해도 log.Fatalf처럼 전체 invocation과 형제 goroutine들을
+	// 죽이지 않도록, errgroup으로 에러를 모으고 동시성 상한을 둡니다.
+	g, _ := errgroup.WithContext(ctx)
+	g.SetLimit(maxConcurrency())
 
 	log.Println("Reading s3 records..")
 	for _, record := range s3Event.Records {
-		wg.Add(1)
-		go handleImage(&wg, client, &record)
+		record := record
+		g.Go(func() error {
+			if err := handleImage(client, uploader, cfg, store, &record); err != nil {
+				// 레코드 하나의 실패로 전체 invocation이 재시도되어 다른 레코드까지
+				// 다시 처리되는 것을 막기 위해, DLQ가 설정되어 있으면 그쪽으로 넘깁니다.
+				return publishToDLQ(dlqClient, record.S3.Bucket.Name, record.S3.Object.Key, err)
+			}
+			return nil
+		})
 	}
-	wg.Wait()
+	return g.Wait()
 }
 
 func main() {
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+
+	"golang.org/x/image/draw"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"golang.org/x/image/bmp"
+)
+
+// CropMode는 파생 이미지(variant)를 목표 크기에 맞출 때 원본 비율을 어떻게 다룰지 정합니다.
+type CropMode string
+
+const (
+	CropFit         CropMode = "fit"          // 비율을 유지한 채 목표 크기 안에 모두 들어오도록 축소
+	CropFill        CropMode = "fill"         // 비율을 유지한 채 채우고, 넘치는 부분은 중앙 기준으로 잘라냄
+	CropSmartCenter CropMode = "smart-center" // 현재는 fill과 동일하게 중앙 기준 크롭(피사체 인식은 추후 과제)
+)
+
+// Variant는 워터마크된 원본과 함께 만들어질 파생 이미지(리사이즈본) 하나를 기술합니다.
+type Variant struct {
+	Name        string   `json:"name"`
+	Width       int      `json:"width"`
+	Height      int      `json:"height"`
+	Mode        CropMode `json:"mode"`
+	JPEGQuality int      `json:"jpeg_quality,omitempty"`
+}
+
+func defaultVariants() []Variant {
+	return []Variant{
+		{Name: "thumb-128", Width: 128, Height: 128, Mode: CropFill, JPEGQuality: 80},
+		{Name: "medium-512", Width: 512, Height: 512, Mode: CropFit, JPEGQuality: 85},
+		{Name: "large-1600", Width: 1600, Height: 1600, Mode: CropFit, JPEGQuality: 90},
+	}
+}
+
+// loadVariants는 RESIZE_VARIANTS 환경 변수(JSON 배열)로 파생 이미지 목록을 설정할 수
+// 있게 해주며, 지정하지 않으면 defaultVariants를 씁니다. "[]"을 지정하면 파생 이미지를
+// 아예 만들지 않습니다.
+func loadVariants() ([]Variant, error) {
+	raw := os.Getenv("RESIZE_VARIANTS")
+	if raw == "" {
+		return defaultVariants(), nil
+	}
+	var variants []Variant
+	if err := json.Unmarshal([]byte(raw), &variants); err != nil {
+		return nil, fmt.Errorf("invalid RESIZE_VARIANTS: %w", err)
+	}
+	return variants, nil
+}
+
+// resizeTo는 src를 variant.Mode에 따라 Width x Height로 리샘플링합니다. CatmullRom
+// 커널(x/image/draw)을 사용해 Lanczos에 준하는 품질로 축소/확대합니다.
+func resizeTo(src image.Image, v Variant) image.Image {
+	srcBounds := src.Bounds()
+	sw, sh := srcBounds.Dx(), srcBounds.Dy()
+	if sw == 0 || sh == 0 || v.Width <= 0 || v.Height <= 0 {
+		return src
+	}
+
+	if v.Mode == CropFill || v.Mode == CropSmartCenter {
+		scale := maxFloat(float64(v.Width)/float64(sw), float64(v.Height)/float64(sh))
+		scaled := scaleImage(src, srcBounds, scale)
+
+		// 스케일된 이미지의 중앙에서 목표 크기만큼 잘라냅니다.
+		sb := scaled.Bounds()
+		x0 := sb.Min.X + (sb.Dx()-v.Width)/2
+		y0 := sb.Min.Y + (sb.Dy()-v.Height)/2
+		cropRect := image.Rect(x0, y0, x0+v.Width, y0+v.Height)
+
+		dst := image.NewRGBA(image.Rect(0, 0, v.Width, v.Height))
+		draw.Draw(dst, dst.Bounds(), scaled, cropRect.Min, draw.Src)
+		return dst
+	}
+
+	// fit: 비율을 유지한 채 목표 크기 안에 전부 들어오도록 축소(크롭 없음).
+	scale := minFloat(float64(v.Width)/float64(sw), float64(v.Height)/float64(sh))
+	return scaleImage(src, srcBounds, scale)
+}
+
+func scaleImage(src image.Image, srcBounds image.Rectangle, scale float64) *image.RGBA {
+	w := int(float64(srcBounds.Dx())*scale + 0.5)
+	h := int(float64(srcBounds.Dy())*scale + 0.5)
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, srcBounds, draw.Over, nil)
+	return dst
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// encodeVariant는 variant 이미지를 ext 포맷(인코더가 없으면 resolveOutputExt가 정한
+// 대체 포맷)으로 인코딩합니다. JPEG은 quality를, PNG는 항상 BestCompression을 씁니다.
+func encodeVariant(img image.Image, ext string, quality int) (*bytes.Buffer, string, error) {
+	buf := new(bytes.Buffer)
+	outExt := resolveOutputExt(ext)
+
+	var err error
+	switch outExt {
+	case "jpeg", "jpg":
+		if quality <= 0 {
+			quality = jpeg.DefaultQuality
+		}
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+	case "bmp":
+		err = bmp.Encode(buf, img)
+	default:
+		outExt = "png"
+		enc := png.Encoder{CompressionLevel: png.BestCompression}
+		err = enc.Encode(buf, img)
+	}
+	return buf, outExt, err
+}
+
+// writeResizeVariants는 labeledImage(이미 워터마크가 적용된 원본 크기 이미지)로부터
+// 각 variant를 만들어 variant.Name을 접두사로 하는 별도 키에 업로드합니다. 이미 원본
+// 전체에 워터마크를 그린 뒤 리샘플링하므로, 라벨도 각 파생본의 크기에 비례해 함께
+// 축소/확대됩니다.
+func writeResizeVariants(uploader *s3manager.Uploader, labeledImage image.Image, bucket, key, ext string, variants []Variant) error {
+	for _, v := range variants {
+		resized := resizeTo(labeledImage, v)
+		buf, outExt, err := encodeVariant(resized, ext, v.JPEGQuality)
+		if err != nil {
+			return fmt.Errorf("encode variant %q for %q: %w", v.Name, key, err)
+		}
+
+		destKey := v.Name + "/" + basenameWithExt(key, outExt)
+		_, err = uploader.Upload(&s3manager.UploadInput{
+			Bucket:       aws.String(bucket),
+			Key:          aws.String(destKey),
+			Body:         bytes.NewReader(buf.Bytes()),
+			ContentType:  aws.String(fmt.Sprintf("image/%v", outExt)),
+			CacheControl: aws.String("public, max-age=31536000, immutable"),
+		})
+		if err != nil {
+			return fmt.Errorf("upload variant %q for %q: %w", v.Name, key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func solidImage(w, h int, c color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestResizeTo_Fit(t *testing.T) {
+	src := solidImage(1000, 500, color.White)
+	out := resizeTo(src, Variant{Width: 200, Height: 200, Mode: CropFit})
+
+	b := out.Bounds()
+	if b.Dx() != 200 || b.Dy() != 100 {
+		t.Errorf("fit mode got %dx%d, want 200x100 (aspect ratio preserved, no crop)", b.Dx(), b.Dy())
+	}
+}
+
+func TestResizeTo_Fill(t *testing.T) {
+	src := solidImage(1000, 500, color.White)
+	out := resizeTo(src, Variant{Width: 200, Height: 200, Mode: CropFill})
+
+	b := out.Bounds()
+	if b.Dx() != 200 || b.Dy() != 200 {
+		t.Errorf("fill mode got %dx%d, want 200x200 (target fully covered, excess cropped)", b.Dx(), b.Dy())
+	}
+}
+
+func TestResizeTo_ZeroDimension(t *testing.T) {
+	src := solidImage(100, 100, color.White)
+	out := resizeTo(src, Variant{Width: 0, Height: 0, Mode: CropFit})
+	if out != src {
+		t.Error("expected resizeTo to return the source unchanged for a zero-sized variant")
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"gopkg.in/yaml.v3"
+)
+
+// Position은 워터마크(라벨, 로고)를 이미지의 어느 모서리에 앵커할지를 나타냅니다.
+type Position string
+
+const (
+	TopLeft     Position = "TopLeft"
+	TopRight    Position = "TopRight"
+	Center      Position = "Center"
+	BottomLeft  Position = "BottomLeft"
+	BottomRight Position = "BottomRight"
+)
+
+// Label은 하나의 텍스트 워터마크를 나타냅니다. FontPath가 비어있으면 기존과 동일하게
+// basicfont.Face7x13을 사용하고, 채워져 있으면 해당 TTF 폰트를 FontSize로 렌더링합니다.
+type Label struct {
+	Text     string   `json:"text" yaml:"text"`
+	FontPath string   `json:"font_path,omitempty" yaml:"font_path,omitempty"`
+	FontSize float64  `json:"font_size,omitempty" yaml:"font_size,omitempty"`
+	Color    string   `json:"color,omitempty" yaml:"color,omitempty"` // "RRGGBBAA", 기본은 불투명 빨강
+	Position Position `json:"position,omitempty" yaml:"position,omitempty"`
+	Dx       int      `json:"dx,omitempty" yaml:"dx,omitempty"`
+	Dy       int      `json:"dy,omitempty" yaml:"dy,omitempty"`
+}
+
+// LogoOverlay는 이미지 위에 겹쳐 그릴 로고(PNG 등)를 나타냅니다.
+type LogoOverlay struct {
+	ImagePath string   `json:"image_path" yaml:"image_path"`
+	Alpha     float64  `json:"alpha,omitempty" yaml:"alpha,omitempty"` // 0~1, 기본 1
+	Position  Position `json:"position,omitempty" yaml:"position,omitempty"`
+	Dx        int      `json:"dx,omitempty" yaml:"dx,omitempty"`
+	Dy        int      `json:"dy,omitempty" yaml:"dy,omitempty"`
+}
+
+// Config는 워터마크 하나의 전체 설정으로, 여러 개의 라벨과 로고를 쌓아서(stack) 그릴 수 있습니다.
+type Config struct {
+	Labels []Label       `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Logos  []LogoOverlay `json:"logos,omitempty" yaml:"logos,omitempty"`
+}
+
+// 콜드 스타트당 한 번만 설정과 폰트를 읽어오면 되므로 캐시해둡니다.
+var (
+	fontCache sync.Map // map[string]*truetype.Font
+	logoCache sync.Map // map[string]image.Image
+
+	configMu     sync.Mutex
+	cachedConfig *Config
+)
+
+// LoadConfig는 환경 변수로 지정된 인라인 JSON/YAML 문자열 또는 S3 객체로부터 워터마크
+// 설정을 읽어옵니다. WATERMARK_CONFIG 환경 변수가 있으면 그 내용을 바로 파싱하고,
+// 없으면 WATERMARK_CONFIG_BUCKET/WATERMARK_CONFIG_KEY로 지정된 S3 객체를 내려받습니다.
+// 둘 다 비어있으면 기존 동작과 동일한 기본 설정(단일 "This is watermark" 라벨)을 반환합니다.
+// fontCache와 마찬가지로 콜드 스타트당 한 번만 읽으면 되므로, 성공한 결과만 캐시해
+// 워밍(warm) 상태로 재사용되는 호출에서 매번 S3 GetObject를 다시 하지 않습니다. 실패는
+// 캐시하지 않습니다 — sync.Once로 캐시하면 일시적인 S3 오류가 그 워밍 컨테이너의 남은
+// 수명 동안 영구적으로 설정 로드를 실패시키게 되므로, 다음 호출에서 다시 시도합니다.
+func LoadConfig(client *s3.S3) (*Config, error) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	if cachedConfig != nil {
+		return cachedConfig, nil
+	}
+	cfg, err := loadConfig(client)
+	if err != nil {
+		return nil, err
+	}
+	cachedConfig = cfg
+	return cachedConfig, nil
+}
+
+func loadConfig(client *s3.S3) (*Config, error) {
+	if inline := os.Getenv("WATERMARK_CONFIG"); inline != "" {
+		return parseConfig(inline, "inline.json")
+	}
+
+	bucket := os.Getenv("WATERMARK_CONFIG_BUCKET")
+	key := os.Getenv("WATERMARK_CONFIG_KEY")
+	if bucket == "" || key == "" {
+		return defaultConfig(), nil
+	}
+
+	resp, err := client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to load watermark config from s3://%s/%s: %w", bucket, key, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read watermark config body: %w", err)
+	}
+
+	return parseConfig(string(body), key)
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Labels: []Label{{Text: "This is watermark", Position: TopLeft}},
+	}
+}
+
+// parseConfig는 source 내용이 JSON인지 YAML인지를 파일명/내용으로 짐작해 파싱합니다.
+func parseConfig(content, hint string) (*Config, error) {
+	cfg := &Config{}
+	if strings.HasSuffix(strings.ToLower(hint), ".yaml") || strings.HasSuffix(strings.ToLower(hint), ".yml") {
+		if err := yaml.Unmarshal([]byte(content), cfg); err != nil {
+			return nil, fmt.Errorf("invalid watermark config (yaml): %w", err)
+		}
+		return cfg, nil
+	}
+	if err := json.Unmarshal([]byte(content), cfg); err != nil {
+		return nil, fmt.Errorf("invalid watermark config (json): %w", err)
+	}
+	return cfg, nil
+}
+
+// ApplyOverrides는 S3 객체의 사용자 메타데이터(x-amz-meta-watermark-text,
+// x-amz-meta-watermark-position)로 단일 라벨 설정을 덮어씁니다. 업로더가 Lambda를
+// 재배포하지 않고도 개별 객체의 워터마크 문구/위치를 바꿀 수 있게 해줍니다.
+func ApplyOverrides(cfg *Config, metadata map[string]*string) *Config {
+	text := metadataValue(metadata, "watermark-text")
+	position := metadataValue(metadata, "watermark-position")
+	if text == "" && position == "" {
+		return cfg
+	}
+
+	overridden := *cfg
+	overridden.Labels = append([]Label(nil), cfg.Labels...)
+	if len(overridden.Labels) == 0 {
+		overridden.Labels = []Label{{Position: TopLeft}}
+	}
+	if text != "" {
+		overridden.Labels[0].Text = text
+	}
+	if position != "" {
+		overridden.Labels[0].Position = Position(position)
+	}
+	return &overridden
+}
+
+func metadataValue(metadata map[string]*string, suffix string) string {
+	for k, v := range metadata {
+		if v == nil {
+			continue
+		}
+		if strings.EqualFold(k, suffix) {
+			return *v
+		}
+	}
+	return ""
+}
+
+// Apply는 cfg에 기술된 모든 라벨과 로고를 img 위에 순서대로 그립니다. 위치 앵커는
+// img 자신의 Bounds()를 기준으로 계산합니다.
+func Apply(img draw.Image, cfg *Config, s3Client *s3.S3) error {
+	return ApplyWithCanvas(img, img.Bounds(), cfg, s3Client)
+}
+
+// ApplyWithCanvas는 Apply와 같지만, "BottomRight" 등 위치 앵커를 img 자신이 아니라
+// canvas 사각형을 기준으로 계산합니다. 애니메이션 GIF의 개별 프레임처럼, 실제로 그려질
+// img의 Bounds()가 전체 논리 화면(canvas)의 일부만 차지하는 경우(디스포절 최적화로
+// 잘려나간 Rect) 프레임 자신의 크기로 앵커하면 위치가 프레임마다 달라지므로, 전체
+// 캔버스 크기로 한 번만 앵커를 계산해 모든 프레임에 일관되게 적용해야 합니다.
+func ApplyWithCanvas(img draw.Image, canvas image.Rectangle, cfg *Config, s3Client *s3.S3) error {
+	for _, label := range cfg.Labels {
+		if err := drawLabel(img, canvas, label); err != nil {
+			return fmt.Errorf("draw label %q: %w", label.Text, err)
+		}
+	}
+	for _, logo := range cfg.Logos {
+		if err := drawLogo(img, canvas, logo, s3Client); err != nil {
+			return fmt.Errorf("draw logo %q: %w", logo.ImagePath, err)
+		}
+	}
+	return nil
+}
+
+func drawLabel(dst draw.Image, canvas image.Rectangle, label Label) error {
+	if label.Text == "" {
+		return nil
+	}
+
+	face, err := resolveFace(label.FontPath, label.FontSize)
+	if err != nil {
+		return err
+	}
+
+	col := parseColor(label.Color)
+	width := font.MeasureString(face, label.Text).Ceil()
+	metrics := face.Metrics()
+	height := metrics.Height.Ceil()
+	ascent := metrics.Ascent.Ceil()
+	x, y := anchor(canvas, width, height, label.Position, label.Dx, label.Dy)
+
+	d := &font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(col),
+		Face: face,
+		Dot:  fixed.Point26_6{X: fixed.Int26_6(x * 64), Y: fixed.Int26_6((y + ascent) * 64)},
+	}
+	d.DrawString(label.Text)
+	return nil
+}
+
+func drawLogo(dst draw.Image, canvas image.Rectangle, logo LogoOverlay, client *s3.S3) error {
+	logoImg, err := loadLogoImage(logo.ImagePath, client)
+	if err != nil {
+		return err
+	}
+
+	bounds := logoImg.Bounds()
+	x, y := anchor(canvas, bounds.Dx(), bounds.Dy(), logo.Position, logo.Dx, logo.Dy)
+	target := image.Rect(x, y, x+bounds.Dx(), y+bounds.Dy())
+
+	alpha := logo.Alpha
+	if alpha <= 0 {
+		alpha = 1
+	}
+	mask := image.NewUniform(color.Alpha{A: uint8(alpha * 255)})
+	draw.DrawMask(dst, target, logoImg, bounds.Min, mask, image.Point{}, draw.Over)
+	return nil
+}
+
+// anchor는 position과 오프셋(Dx, Dy)으로부터 그릴 대상의 좌상단 좌표를 계산합니다.
+func anchor(bounds image.Rectangle, w, h int, position Position, dx, dy int) (int, int) {
+	var x, y int
+	switch position {
+	case TopRight:
+		x, y = bounds.Max.X-w, bounds.Min.Y
+	case Center:
+		x, y = bounds.Min.X+(bounds.Dx()-w)/2, bounds.Min.Y+(bounds.Dy()-h)/2
+	case BottomLeft:
+		x, y = bounds.Min.X, bounds.Max.Y-h
+	case BottomRight:
+		x, y = bounds.Max.X-w, bounds.Max.Y-h
+	default: // TopLeft
+		x, y = bounds.Min.X, bounds.Min.Y
+	}
+	return x + dx, y + dy
+}
+
+func parseColor(hex string) color.RGBA {
+	if hex == "" {
+		return color.RGBA{255, 0, 0, 255}
+	}
+	hex = strings.TrimPrefix(hex, "#")
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return color.RGBA{255, 0, 0, 255}
+	}
+	r, _ := strconv.ParseUint(hex[0:2], 16, 8)
+	g, _ := strconv.ParseUint(hex[2:4], 16, 8)
+	b, _ := strconv.ParseUint(hex[4:6], 16, 8)
+	a, _ := strconv.ParseUint(hex[6:8], 16, 8)
+	return color.RGBA{uint8(r), uint8(g), uint8(b), uint8(a)}
+}
+
+// resolveFace는 fontPath가 비어있으면 기존 basicfont를, 아니면 캐시된(또는 새로
+// 로드한) TTF 폰트를 size 포인트로 렌더링한 font.Face를 반환합니다.
+func resolveFace(fontPath string, size float64) (font.Face, error) {
+	if fontPath == "" {
+		return basicfont.Face7x13, nil
+	}
+	if size <= 0 {
+		size = 24
+	}
+
+	f, err := loadTTF(fontPath)
+	if err != nil {
+		return nil, err
+	}
+	return truetype.NewFace(f, &truetype.Options{Size: size}), nil
+}
+
+func loadTTF(path string) (*truetype.Font, error) {
+	if cached, ok := fontCache.Load(path); ok {
+		return cached.(*truetype.Font), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read font %q: %w", path, err)
+	}
+	f, err := truetype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse font %q: %w", path, err)
+	}
+	fontCache.Store(path, f)
+	return f, nil
+}
+
+// loadLogoImage는 로컬 경로 혹은 s3://bucket/key 형태의 경로로부터 로고 이미지를 읽어옵니다.
+// fontCache와 마찬가지로 경로별로 디코딩된 이미지를 캐시합니다 — 애니메이션 GIF는 프레임마다
+// drawLogo를 호출하므로, 캐시가 없으면 같은 로고를 프레임 수만큼 반복해서 내려받게 됩니다.
+func loadLogoImage(path string, client *s3.S3) (image.Image, error) {
+	if cached, ok := logoCache.Load(path); ok {
+		return cached.(image.Image), nil
+	}
+
+	img, err := fetchLogoImage(path, client)
+	if err != nil {
+		return nil, err
+	}
+	logoCache.Store(path, img)
+	return img, nil
+}
+
+func fetchLogoImage(path string, client *s3.S3) (image.Image, error) {
+	if rest, ok := strings.CutPrefix(path, "s3://"); ok {
+		parts := strings.SplitN(rest, "/", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid s3 logo path %q", path)
+		}
+		resp, err := client.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(parts[0]),
+			Key:    aws.String(parts[1]),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to download logo %q: %w", path, err)
+		}
+		defer resp.Body.Close()
+		img, _, err := image.Decode(resp.Body)
+		return img, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open logo %q: %w", path, err)
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestAnchor(t *testing.T) {
+	bounds := image.Rect(0, 0, 100, 50)
+	const w, h = 20, 10
+
+	cases := []struct {
+		position Position
+		wantX    int
+		wantY    int
+	}{
+		{TopLeft, 0, 0},
+		{TopRight, 80, 0},
+		{Center, 40, 20},
+		{BottomLeft, 0, 40},
+		{BottomRight, 80, 40},
+	}
+	for _, c := range cases {
+		x, y := anchor(bounds, w, h, c.position, 0, 0)
+		if x != c.wantX || y != c.wantY {
+			t.Errorf("anchor(%s) = (%d, %d), want (%d, %d)", c.position, x, y, c.wantX, c.wantY)
+		}
+	}
+}
+
+func TestAnchor_Offset(t *testing.T) {
+	bounds := image.Rect(0, 0, 100, 50)
+	x, y := anchor(bounds, 20, 10, BottomRight, 5, -5)
+	if x != 85 || y != 35 {
+		t.Errorf("anchor with Dx/Dy offset = (%d, %d), want (85, 35)", x, y)
+	}
+}
+
+func TestAnchor_UnknownPositionFallsBackToTopLeft(t *testing.T) {
+	bounds := image.Rect(0, 0, 100, 50)
+	x, y := anchor(bounds, 20, 10, Position("bogus"), 0, 0)
+	if x != 0 || y != 0 {
+		t.Errorf("anchor with unknown position = (%d, %d), want (0, 0) (TopLeft default)", x, y)
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	cases := []struct {
+		name string
+		hex  string
+		want color.RGBA
+	}{
+		{"empty defaults to opaque red", "", color.RGBA{255, 0, 0, 255}},
+		{"6-digit RGB defaults to opaque", "00ff00", color.RGBA{0, 255, 0, 255}},
+		{"8-digit RGBA honors alpha", "0000ff80", color.RGBA{0, 0, 255, 0x80}},
+		{"leading # is stripped", "#ff000080", color.RGBA{255, 0, 0, 0x80}},
+		{"invalid length falls back to opaque red", "abc", color.RGBA{255, 0, 0, 255}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := parseColor(c.hex); got != c.want {
+				t.Errorf("parseColor(%q) = %+v, want %+v", c.hex, got, c.want)
+			}
+		})
+	}
+}